@@ -1,27 +1,45 @@
 package imagegetter
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/containerd/containerd/archive/compression"
 	ctrimages "github.com/containerd/containerd/cmd/ctr/commands/images"
 	"github.com/containerd/containerd/content"
+	localcontent "github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
 	"github.com/containerd/containerd/pkg/transfer"
 	"github.com/containerd/containerd/pkg/transfer/archive"
 	"github.com/containerd/containerd/pkg/transfer/image"
 	transimage "github.com/containerd/containerd/pkg/transfer/image"
+	localtransfer "github.com/containerd/containerd/pkg/transfer/local"
 	"github.com/containerd/containerd/pkg/transfer/registry"
 	"github.com/containerd/containerd/platforms"
 	refdocker "github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/log"
+	dockertransport "github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/shortnames"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/signature"
+	storageTransport "github.com/containers/image/v5/storage"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/reproducible-containers/diffoci/cmd/diffoci/backend"
 	"github.com/reproducible-containers/diffoci/pkg/dockercred"
@@ -51,21 +69,105 @@ func Load(ctx context.Context, stdout io.Writer, transferrer transfer.Transferre
 	return nil
 }
 
-func Pull(ctx context.Context, stdout io.Writer, transferrer transfer.Transferrer, credHelper registry.CredentialHelper, ref string, plats []ocispec.Platform) error {
-	reg := registry.NewOCIRegistry(ref, nil, credHelper)
-
+// Pull transfers ref from the registry into the backend. If policyCtx is
+// non-nil, the remote manifest and its signatures are checked against the
+// policy before anything is transferred, and rejection is reported as
+// *ErrPolicyRejected. If mirrors configures a mirror for ref's registry,
+// each mirror is tried, in order, before falling back to ref itself.
+func Pull(ctx context.Context, stdout io.Writer, transferrer transfer.Transferrer, credHelper registry.CredentialHelper, policyCtx *signature.PolicyContext, mirrors *MirrorConfig, ref string, plats []ocispec.Platform) error {
 	sOpts := []transimage.StoreOpt{
 		transimage.WithPlatforms(plats...),
 	}
+	// The image is always registered in the local store under ref, even
+	// when it's actually fetched from a mirror, so that both sides of a
+	// diff can keep referring to the canonical name.
 	is := transimage.NewStore(ref, sOpts...)
 
-	pf, done := ctrimages.ProgressHandler(ctx, stdout)
-	defer done()
+	var lastErr error
+	for _, candidate := range mirrors.candidates(ref) {
+		// Policy must be checked against whatever is actually transferred:
+		// a mirror can serve different bytes than the source registry, so
+		// verifying ref alone (before picking a candidate) would let a
+		// compromised or stale mirror bypass enforcement entirely.
+		if policyCtx != nil {
+			if err := verifyPolicy(ctx, policyCtx, candidate); err != nil {
+				if candidate != ref {
+					log.G(ctx).Warnf("mirror %q rejected by signing policy for %q, falling back: %v", candidate, ref, err)
+					lastErr = err
+					continue
+				}
+				return err
+			}
+		}
+		reg := registry.NewOCIRegistry(candidate, nil, credHelper)
+		pf, done := ctrimages.ProgressHandler(ctx, stdout)
+		err := transferrer.Transfer(ctx, reg, is, transfer.WithProgress(pf))
+		done()
+		if err == nil {
+			return nil
+		}
+		if candidate != ref && isMirrorFallbackError(err) {
+			log.G(ctx).Warnf("mirror %q failed for %q, falling back: %v", candidate, ref, err)
+			lastErr = err
+			continue
+		}
+		return fmt.Errorf("failed to pull %q: %w", candidate, err)
+	}
+	return fmt.Errorf("failed to pull %q: %w", ref, lastErr)
+}
 
-	if err := transferrer.Transfer(ctx, reg, is, transfer.WithProgress(pf)); err != nil {
-		return fmt.Errorf("failed to pull %q: %w", ref, err)
+// isMirrorFallbackError reports whether err looks like the kind of failure
+// (not found, or an auth failure) that should make Pull fall back to the
+// next mirror (or the original registry) rather than giving up immediately.
+func isMirrorFallbackError(err error) bool {
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return true
 	}
-	return nil
+	msg := err.Error()
+	return strings.Contains(msg, "unauthorized") || strings.Contains(msg, "403") || strings.Contains(msg, "404")
+}
+
+// ErrPolicyRejected is returned when an image is rejected by the
+// containers/image signing policy configured on the ImageGetter.
+type ErrPolicyRejected struct {
+	Ref         string
+	Requirement string
+}
+
+func (e *ErrPolicyRejected) Error() string {
+	return fmt.Sprintf("image %q rejected by signing policy: %s", e.Ref, e.Requirement)
+}
+
+// verifyPolicy checks ref's remote manifest and signatures against
+// policyCtx, without downloading anything beyond what policy enforcement
+// itself requires (e.g. simple-signing signatures).
+func verifyPolicy(ctx context.Context, policyCtx *signature.PolicyContext, ref string) error {
+	dref, err := dockertransport.Transport.ParseReference("//" + ref)
+	if err != nil {
+		return fmt.Errorf("failed to parse %q for policy verification: %w", ref, err)
+	}
+	src, err := dref.NewImageSource(ctx, &types.SystemContext{})
+	if err != nil {
+		return fmt.Errorf("failed to open %q for policy verification: %w", ref, err)
+	}
+	defer src.Close()
+	allowed, err := policyCtx.IsRunningImageAllowed(ctx, src)
+	if !allowed {
+		if err == nil {
+			err = errors.New("no policy requirement was satisfied")
+		}
+		// IsRunningImageAllowed documents that a rejection is reported as a
+		// signature.PolicyRequirementError "if possible", but a plain fetch
+		// or transport failure encountered while evaluating the policy
+		// (e.g. the signature storage being unreachable) surfaces here too.
+		// Only the former is a genuine policy rejection.
+		var reqErr signature.PolicyRequirementError
+		if errors.As(err, &reqErr) {
+			return &ErrPolicyRejected{Ref: ref, Requirement: err.Error()}
+		}
+		return fmt.Errorf("failed to verify signing policy for %q: %w", ref, err)
+	}
+	return err
 }
 
 type ImageGetter struct {
@@ -74,6 +176,237 @@ type ImageGetter struct {
 	contentStore   content.Store
 	transferrer    transfer.Transferrer
 	credHelper     registry.CredentialHelper
+	shortNameMode  ShortNameMode
+	policyCtx      *signature.PolicyContext
+	mirrors        *MirrorConfig
+}
+
+// SetMirrorConfig configures the registry mirrors (pull-through caches)
+// consulted by subsequent Pull calls.
+func (g *ImageGetter) SetMirrorConfig(mc *MirrorConfig) {
+	g.mirrors = mc
+}
+
+// PullFromMirror mirrors registries.conf's [[registry]].mirror
+// pull-from-mirror values, restricting a mirror to tag- or digest-pinned
+// references.
+type PullFromMirror string
+
+const (
+	PullFromMirrorAll        PullFromMirror = "all"
+	PullFromMirrorTagOnly    PullFromMirror = "tag-only"
+	PullFromMirrorDigestOnly PullFromMirror = "digest-only"
+)
+
+// Mirror is a single pull-through cache registered for a source registry.
+//
+// registries.conf's mirror stanzas also carry an "insecure" flag for plain
+// HTTP or unverified-TLS mirrors, but registry.NewOCIRegistry currently has
+// no way to take a per-candidate transport override, so that flag isn't
+// read or exposed here; an insecure mirror will be dialed as verified HTTPS
+// and fail until NewOCIRegistry grows that hook.
+type Mirror struct {
+	Location       string
+	PullFromMirror PullFromMirror
+}
+
+// MirrorOption customizes a Mirror registered via MirrorConfig.WithMirror.
+type MirrorOption func(*Mirror)
+
+// WithPullFromMirror restricts the mirror to tag- or digest-pinned
+// references (it defaults to PullFromMirrorAll).
+func WithPullFromMirror(mode PullFromMirror) MirrorOption {
+	return func(m *Mirror) { m.PullFromMirror = mode }
+}
+
+// MirrorConfig maps a source registry host (e.g. "docker.io") to the
+// mirrors that Pull should try, in order, before falling back to the
+// source itself.
+type MirrorConfig struct {
+	bySource map[string][]Mirror
+}
+
+// NewMirrorConfig returns an empty MirrorConfig.
+func NewMirrorConfig() *MirrorConfig {
+	return &MirrorConfig{bySource: make(map[string][]Mirror)}
+}
+
+// WithMirror registers mirror as a pull-through cache for source, e.g.
+// WithMirror("docker.io", "zot.example.com:5000"). It returns mc for
+// chaining.
+func (mc *MirrorConfig) WithMirror(source, mirror string, opts ...MirrorOption) *MirrorConfig {
+	m := Mirror{Location: mirror, PullFromMirror: PullFromMirrorAll}
+	for _, opt := range opts {
+		opt(&m)
+	}
+	mc.bySource[source] = append(mc.bySource[source], m)
+	return mc
+}
+
+// LoadMirrorConfigFromSystem reads the [[registry]].mirror stanzas from the
+// system's registries.conf (and registries.conf.d) via containers/image's
+// sysregistriesv2.
+func LoadMirrorConfigFromSystem(sys *types.SystemContext) (*MirrorConfig, error) {
+	registries, err := sysregistriesv2.TryUpdatingCache(sys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registries.conf: %w", err)
+	}
+	mc := NewMirrorConfig()
+	for _, reg := range registries {
+		for _, m := range reg.Mirrors {
+			mode := PullFromMirror(m.PullFromMirror)
+			if mode == "" {
+				mode = PullFromMirrorAll
+			}
+			mc.WithMirror(reg.Prefix, m.Location, WithPullFromMirror(mode))
+		}
+	}
+	return mc, nil
+}
+
+// candidates returns the ordered list of fully qualified references to try
+// for ref: first any mirrors registered for ref's registry (filtered by
+// PullFromMirror against whether ref is tag- or digest-pinned), then ref
+// itself. It is nil-receiver safe: a nil *MirrorConfig yields just ref.
+func (mc *MirrorConfig) candidates(ref string) []string {
+	if mc == nil {
+		return []string{ref}
+	}
+	named, err := refdocker.ParseDockerRef(ref)
+	if err != nil {
+		return []string{ref}
+	}
+	host := refdocker.Domain(named)
+	path := refdocker.Path(named)
+	_, isDigested := named.(refdocker.Digested)
+
+	trimPrefix, mirrors := mc.lookup(host+"/"+path, host)
+	if mirrors == nil {
+		return []string{ref}
+	}
+	rest := strings.TrimPrefix(ref, trimPrefix)
+
+	var out []string
+	for _, m := range mirrors {
+		switch m.PullFromMirror {
+		case PullFromMirrorDigestOnly:
+			if !isDigested {
+				continue
+			}
+		case PullFromMirrorTagOnly:
+			if isDigested {
+				continue
+			}
+		}
+		out = append(out, m.Location+rest)
+	}
+	return append(out, ref)
+}
+
+// lookup finds the most specific registries.conf-style prefix registered in
+// mc.bySource that matches an image's full "host/path" and host, per
+// sysregistriesv2 semantics: a prefix can be a bare registry host
+// ("docker.io"), a path-scoped prefix ("docker.io/library"), or a
+// leading-wildcard domain ("*.example.com"). Specificity is measured by the
+// length of the registered prefix, matching how sysregistriesv2 itself
+// picks among overlapping entries. It returns the literal substring of the
+// reference that a mirror's location should replace, and the mirrors
+// registered under the winning prefix (nil if none matched).
+func (mc *MirrorConfig) lookup(full, host string) (trimPrefix string, mirrors []Mirror) {
+	bestConfLen := -1
+	for confPrefix, ms := range mc.bySource {
+		trim, ok := matchConfPrefix(confPrefix, full, host)
+		if !ok {
+			continue
+		}
+		if len(confPrefix) > bestConfLen {
+			bestConfLen = len(confPrefix)
+			trimPrefix = trim
+			mirrors = ms
+		}
+	}
+	return trimPrefix, mirrors
+}
+
+// matchConfPrefix reports whether confPrefix (as found in registries.conf's
+// [[registry]] prefix) applies to an image whose fully qualified name is
+// full ("host/path") and whose registry host is host. On a match it also
+// returns the substring of a reference that should be trimmed and replaced
+// by a mirror's location: the whole confPrefix for an exact or path-scoped
+// match, or just host for a wildcard domain (so the repository path is
+// preserved on the mirror). A "*.example.com" prefix matches only strict
+// subdomains of example.com, not example.com itself.
+func matchConfPrefix(confPrefix, full, host string) (string, bool) {
+	if rest, ok := strings.CutPrefix(confPrefix, "*."); ok {
+		domain := "." + rest
+		if strings.HasSuffix(host, domain) {
+			return host, true
+		}
+		return "", false
+	}
+	if full == confPrefix || strings.HasPrefix(full, confPrefix+"/") {
+		return confPrefix, true
+	}
+	return "", false
+}
+
+// SetPolicyFromFile loads a containers/image signing policy from path and
+// enables policy enforcement on subsequent pulls. If path is empty, the
+// system default policy (e.g. /etc/containers/policy.json) is loaded via
+// signature.DefaultPolicy; there is no built-in fallback policy, so a
+// missing policy file is reported as an error.
+func (g *ImageGetter) SetPolicyFromFile(path string) error {
+	var (
+		policy *signature.Policy
+		err    error
+	)
+	if path == "" {
+		policy, err = signature.DefaultPolicy(&types.SystemContext{})
+	} else {
+		policy, err = signature.NewPolicyFromFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load signing policy: %w", err)
+	}
+	policyCtx, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return fmt.Errorf("failed to build policy context: %w", err)
+	}
+	g.policyCtx = policyCtx
+	return nil
+}
+
+// verifyLocalPolicy checks a docker-/podman-loaded image's locally stored
+// signatures against g.policyCtx. Unlike verifyPolicy, this reads from the
+// local containers/storage instance rather than the registry, since the
+// image was already loaded via `docker save`/`podman save`.
+func (g *ImageGetter) verifyLocalPolicy(ctx context.Context, name string) error {
+	if g.policyCtx == nil {
+		return nil
+	}
+	ref, err := storageTransport.Transport.ParseReference(name)
+	if err != nil {
+		// Not present in local containers/storage (e.g. Docker Desktop's
+		// non-containers/storage backend): nothing we can verify.
+		return nil
+	}
+	src, err := ref.NewImageSource(ctx, &types.SystemContext{})
+	if err != nil {
+		return nil
+	}
+	defer src.Close()
+	allowed, err := g.policyCtx.IsRunningImageAllowed(ctx, src)
+	if !allowed {
+		if err == nil {
+			err = errors.New("no policy requirement was satisfied")
+		}
+		var reqErr signature.PolicyRequirementError
+		if errors.As(err, &reqErr) {
+			return &ErrPolicyRejected{Ref: name, Requirement: err.Error()}
+		}
+		return fmt.Errorf("failed to verify signing policy for %q: %w", name, err)
+	}
+	return err
 }
 
 func New(progressWriter io.Writer, backend backend.Backend) (*ImageGetter, error) {
@@ -92,6 +425,104 @@ func New(progressWriter io.Writer, backend backend.Backend) (*ImageGetter, error
 
 type PullMode string
 
+// ShortNameMode controls how a bare, unqualified image name (e.g. "alpine")
+// given to a registry reference is expanded to a fully qualified name
+// before it's parsed by refdocker.ParseDockerRef.
+type ShortNameMode string
+
+const (
+	// ShortNamePermissive resolves short names against registries.conf
+	// short-name aliases and unqualified-search-registries, taking the
+	// first candidate when more than one applies (diffoci is
+	// non-interactive, so it cannot prompt like Podman does).
+	ShortNamePermissive ShortNameMode = "permissive"
+	// ShortNameEnforcing is like ShortNamePermissive, but fails instead of
+	// guessing when a name has no alias and resolves to more than one
+	// unqualified-search registry.
+	ShortNameEnforcing ShortNameMode = "enforcing"
+	// ShortNameDockerHubOnly mirrors Podman's Docker-compat API: aliases
+	// are still honored, but an unaliased name always falls back to
+	// docker.io, regardless of unqualified-search-registries.
+	ShortNameDockerHubOnly ShortNameMode = "docker-hub-only"
+	// ShortNameDisabled keeps the historical behavior, where
+	// refdocker.ParseDockerRef alone promotes bare names to
+	// docker.io/library/<name>:latest.
+	ShortNameDisabled ShortNameMode = "disabled"
+)
+
+// SetShortNameMode opts the ImageGetter into short-name alias resolution for
+// registry references (i.e. references that don't use the docker://,
+// podman://, or containers/image transport prefixes). It has no effect
+// until set to something other than ShortNameDisabled.
+func (g *ImageGetter) SetShortNameMode(mode ShortNameMode) {
+	g.shortNameMode = mode
+}
+
+// resolveShortName expands rawRef against registries.conf short-name
+// aliases and unqualified-search-registries, per g.shortNameMode. It
+// returns rawRef unchanged if short-name resolution is disabled or rawRef
+// is already fully qualified.
+func (g *ImageGetter) resolveShortName(ctx context.Context, rawRef string) (string, error) {
+	if g.shortNameMode == "" || g.shortNameMode == ShortNameDisabled {
+		return rawRef, nil
+	}
+	sysCtx := &types.SystemContext{}
+	resolved, err := shortnames.Resolve(sysCtx, rawRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve short name %q: %w", rawRef, err)
+	}
+	candidates := resolved.PullCandidates
+	if len(candidates) == 0 {
+		// Already fully qualified: nothing to do.
+		return rawRef, nil
+	}
+	if desc := resolved.Description(); desc != "" {
+		log.G(ctx).Debugf("%s", desc)
+	}
+	switch g.shortNameMode {
+	case ShortNamePermissive:
+		return candidates[0].Value.String(), nil
+	case ShortNameEnforcing:
+		if len(candidates) > 1 {
+			return "", fmt.Errorf("short name %q is ambiguous across %d unqualified-search registries; add a short-name alias or use a fully qualified name", rawRef, len(candidates))
+		}
+		return candidates[0].Value.String(), nil
+	case ShortNameDockerHubOnly:
+		// A short-name alias resolves to exactly one candidate; multiple
+		// candidates only arise from enumerating several
+		// unqualified-search registries, i.e. a guess rather than an
+		// explicit alias. Honor a single candidate as-is, regardless of
+		// which registry it points at, so an alias like
+		// "alpine = quay.io/foo/alpine" isn't discarded in favor of
+		// guessing docker.io; only fall back to the docker.io guess when
+		// there's no alias and the candidates don't already include one.
+		if len(candidates) == 1 {
+			return candidates[0].Value.String(), nil
+		}
+		for _, c := range candidates {
+			if strings.HasPrefix(c.Value.Name(), "docker.io/") {
+				return c.Value.String(), nil
+			}
+		}
+		return dockerHubFallbackRef(rawRef), nil
+	default:
+		return "", fmt.Errorf("unknown short name mode %q", g.shortNameMode)
+	}
+}
+
+// dockerHubFallbackRef is the reference ShortNameDockerHubOnly falls back
+// to when rawRef has no applicable short-name alias: a bare single-segment
+// name (e.g. "alpine") gets the implicit "library/" namespace, while an
+// already-namespaced name (e.g. "fedora/httpd") is qualified as-is, since
+// injecting "library/" in front of it would produce a reference that never
+// existed on Docker Hub.
+func dockerHubFallbackRef(rawRef string) string {
+	if strings.Contains(rawRef, "/") {
+		return "docker.io/" + rawRef
+	}
+	return "docker.io/library/" + rawRef
+}
+
 const (
 	PullAlways  = "always"
 	PullMissing = "missing"
@@ -99,6 +530,12 @@ const (
 
 	dockerImagePrefix = "docker://"
 	podmanImagePrefix = "podman://"
+
+	// containers/image-style (skopeo) transport prefixes.
+	ociArchiveImagePrefix        = "oci-archive:"
+	ociImagePrefix               = "oci:"
+	dockerArchiveImagePrefix     = "docker-archive:"
+	containersStorageImagePrefix = "containers-storage:"
 )
 
 func (g *ImageGetter) isDocker(rawRef string) bool {
@@ -109,6 +546,36 @@ func (g *ImageGetter) isPodman(rawRef string) bool {
 	return strings.HasPrefix(rawRef, podmanImagePrefix)
 }
 
+func (g *ImageGetter) isOCIArchive(rawRef string) bool {
+	return strings.HasPrefix(rawRef, ociArchiveImagePrefix)
+}
+
+func (g *ImageGetter) isOCI(rawRef string) bool {
+	// "oci-archive:" does not start with "oci:", so this never matches it;
+	// the explicit exclusion is only a safeguard.
+	return strings.HasPrefix(rawRef, ociImagePrefix) && !g.isOCIArchive(rawRef)
+}
+
+func (g *ImageGetter) isDockerArchive(rawRef string) bool {
+	return strings.HasPrefix(rawRef, dockerArchiveImagePrefix)
+}
+
+func (g *ImageGetter) isContainersStorage(rawRef string) bool {
+	return strings.HasPrefix(rawRef, containersStorageImagePrefix)
+}
+
+// splitTransportRef splits a skopeo-style "<path>[:tag]" reference (the part
+// after the transport prefix) into its path and optional tag. Since local
+// paths may themselves contain colons, only the last colon is treated as a
+// tag separator, and only if the text after it contains no path separator.
+func splitTransportRef(s string) (path, tag string) {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 || strings.ContainsRune(s[idx+1:], '/') {
+		return s, ""
+	}
+	return s[:idx], s[idx+1:]
+}
+
 func (g *ImageGetter) getDocker(ctx context.Context, rawRef string, plats []ocispec.Platform) (*images.Image, error) {
 	rawRefTrimmed := strings.TrimPrefix(rawRef, dockerImagePrefix)
 	ref, err := refdocker.ParseDockerRef(rawRefTrimmed)
@@ -137,6 +604,230 @@ func (g *ImageGetter) getPodman(ctx context.Context, rawRef string, plats []ocis
 	return g.loadDocker(ctx, podman, name, plats)
 }
 
+// Source is an archive-like local image input that can be streamed through
+// the existing Load path, without contacting a registry or a docker/podman
+// daemon.
+type Source interface {
+	// Open returns a reader over the archive and the name under which the
+	// resulting image should be registered in the local image store.
+	Open(ctx context.Context) (r io.ReadCloser, foreknownRef string, err error)
+}
+
+// fileArchiveSource is a tarball sitting on local disk, e.g. `docker save`
+// or `oci-archive:` output.
+type fileArchiveSource struct {
+	path string
+}
+
+func (s *fileArchiveSource) Open(ctx context.Context) (io.ReadCloser, string, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %q: %w", s.path, err)
+	}
+	return f, s.path, nil
+}
+
+// ociLayoutSource packs an OCI image layout directory (`oci:` without the
+// "-archive" suffix) into a tar stream on the fly, so it can be fed through
+// the same archive-import path as a `docker save` tarball.
+type ociLayoutSource struct {
+	dir string
+}
+
+func (s *ociLayoutSource) Open(ctx context.Context) (io.ReadCloser, string, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		return nil, "", fmt.Errorf("failed to stat OCI layout %q: %w", s.dir, err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarDirectory(s.dir, pw))
+	}()
+	return pr, s.dir, nil
+}
+
+func tarDirectory(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// getFromSource loads an archive-like Source through the existing Load path
+// and returns the resulting image from the local store.
+func (g *ImageGetter) getFromSource(ctx context.Context, src Source, plats []ocispec.Platform) (*images.Image, error) {
+	r, foreknownRef, err := src.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := Load(ctx, g.progressWriter, g.transferrer, r, plats, foreknownRef); err != nil {
+		return nil, fmt.Errorf("failed to load %q: %w", foreknownRef, err)
+	}
+	img, err := g.imageStore.Get(ctx, foreknownRef)
+	if err != nil {
+		return nil, fmt.Errorf("should have loaded %q, but the loaded image is not accessible: %w", foreknownRef, err)
+	}
+	return &img, nil
+}
+
+// rejectArchiveTag fails loudly when a skopeo-style "path:tag" selector was
+// given for an archive/layout transport, since Load has no way to pick a
+// single image out of a multi-image archive: it always imports whatever
+// archive.NewImageImportStream finds. Silently ignoring tag would make
+// diffoci compare the wrong image without any indication why.
+func rejectArchiveTag(transport, path, tag string) error {
+	if tag != "" {
+		return fmt.Errorf("%s: selecting tag %q out of a multi-image archive is not supported; point %s at a single-image archive instead", transport, tag, path)
+	}
+	return nil
+}
+
+func (g *ImageGetter) getOCIArchive(ctx context.Context, rawRef string, plats []ocispec.Platform) (*images.Image, error) {
+	path, tag := splitTransportRef(strings.TrimPrefix(rawRef, ociArchiveImagePrefix))
+	if err := rejectArchiveTag(ociArchiveImagePrefix, path, tag); err != nil {
+		return nil, err
+	}
+	return g.getFromSource(ctx, &fileArchiveSource{path: path}, plats)
+}
+
+func (g *ImageGetter) getDockerArchive(ctx context.Context, rawRef string, plats []ocispec.Platform) (*images.Image, error) {
+	path, tag := splitTransportRef(strings.TrimPrefix(rawRef, dockerArchiveImagePrefix))
+	if err := rejectArchiveTag(dockerArchiveImagePrefix, path, tag); err != nil {
+		return nil, err
+	}
+	return g.getFromSource(ctx, &fileArchiveSource{path: path}, plats)
+}
+
+func (g *ImageGetter) getOCIDir(ctx context.Context, rawRef string, plats []ocispec.Platform) (*images.Image, error) {
+	dir, tag := splitTransportRef(strings.TrimPrefix(rawRef, ociImagePrefix))
+	if err := rejectArchiveTag(ociImagePrefix, dir, tag); err != nil {
+		return nil, err
+	}
+	return g.getFromSource(ctx, &ociLayoutSource{dir: dir}, plats)
+}
+
+// ociOrDockerManifest covers the fields shared by OCI and Docker manifests,
+// which is all that's needed to enumerate the blobs of an image.
+type ociOrDockerManifest struct {
+	Config ocispec.Descriptor   `json:"config"`
+	Layers []ocispec.Descriptor `json:"layers"`
+}
+
+// ingestBlob copies a single blob into the backend content store, tolerating
+// a blob that is already present.
+func (g *ImageGetter) ingestBlob(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	w, err := content.OpenWriter(ctx, g.contentStore, content.WithRef(desc.Digest.String()), content.WithDescriptor(desc))
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Commit(ctx, desc.Size, desc.Digest)
+}
+
+// getContainersStorage reads an image directly out of a local
+// containers/storage instance (the store used by Podman and Buildah),
+// without shelling out to `podman save`. Blobs are copied into the backend
+// content store by digest.
+func (g *ImageGetter) getContainersStorage(ctx context.Context, rawRef string, plats []ocispec.Platform) (*images.Image, error) {
+	name := strings.TrimPrefix(rawRef, containersStorageImagePrefix)
+	ref, err := storageTransport.Transport.ParseReference(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", rawRef, err)
+	}
+	src, err := ref.NewImageSource(ctx, &types.SystemContext{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q from containers-storage: %w", name, err)
+	}
+	defer src.Close()
+
+	manifestBytes, manifestType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %q: %w", name, err)
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: manifestType,
+		Digest:    digest.FromBytes(manifestBytes),
+		Size:      int64(len(manifestBytes)),
+	}
+	if err := g.ingestBlob(ctx, manifestDesc, bytes.NewReader(manifestBytes)); err != nil {
+		return nil, fmt.Errorf("failed to copy manifest of %q: %w", name, err)
+	}
+
+	var manifest ociOrDockerManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %q: %w", name, err)
+	}
+	for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		blobR, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: desc.Digest, Size: desc.Size}, none.NoCache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob %s of %q: %w", desc.Digest, name, err)
+		}
+		err = g.ingestBlob(ctx, desc, blobR)
+		blobR.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy blob %s of %q: %w", desc.Digest, name, err)
+		}
+	}
+
+	img := images.Image{Name: rawRef, Target: manifestDesc}
+	if _, err := g.imageStore.Create(ctx, img); err != nil {
+		if !errdefs.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to register %q: %w", rawRef, err)
+		}
+		if img, err = g.imageStore.Update(ctx, img); err != nil {
+			return nil, fmt.Errorf("failed to update %q: %w", rawRef, err)
+		}
+	}
+
+	// Check platforms, same as the docker/podman and registry paths: a
+	// containers-storage image is commonly single-platform, so a diff
+	// requesting a platform it lacks must fail rather than silently
+	// comparing the wrong platform.
+	platMC := platforms.Any(plats...)
+	available, _, _, _, err := images.Check(ctx, g.contentStore, img.Target, platMC)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, fmt.Errorf("image %q lacks blobs for additional platforms: %w", rawRef, errdefs.ErrUnavailable)
+	}
+	return &img, nil
+}
+
 type readerWithEOF struct {
 	io.Reader
 }
@@ -183,32 +874,66 @@ func (g *ImageGetter) loadDocker(ctx context.Context, docker, name string, plats
 	if !available {
 		return nil, fmt.Errorf("image %q lacks blobs for additional platforms: %w", name, errdefs.ErrUnavailable)
 	}
+
+	if err := g.verifyLocalPolicy(ctx, name); err != nil {
+		return nil, err
+	}
 	return &img, nil
 }
 
-func (g *ImageGetter) Get(ctx context.Context, rawRef string, plats []ocispec.Platform, pullMode PullMode) (*images.Image, error) {
+// Get resolves rawRef to a local image, pulling it if necessary and
+// permitted by pullMode. The second return value is the fully qualified
+// name the image was actually resolved to: for docker://, podman://, and
+// containers/image transport refs this is rawRef itself; for a registry
+// reference it reflects any short-name alias resolution (see
+// SetShortNameMode), so that callers can report which registry a short
+// name expanded to.
+func (g *ImageGetter) Get(ctx context.Context, rawRef string, plats []ocispec.Platform, pullMode PullMode) (*images.Image, string, error) {
 	if g.isDocker(rawRef) {
-		return g.getDocker(ctx, rawRef, plats)
+		img, err := g.getDocker(ctx, rawRef, plats)
+		return img, rawRef, err
 	}
 	if g.isPodman(rawRef) {
-		return g.getPodman(ctx, rawRef, plats)
+		img, err := g.getPodman(ctx, rawRef, plats)
+		return img, rawRef, err
+	}
+	if g.isOCIArchive(rawRef) {
+		img, err := g.getOCIArchive(ctx, rawRef, plats)
+		return img, rawRef, err
+	}
+	if g.isOCI(rawRef) {
+		img, err := g.getOCIDir(ctx, rawRef, plats)
+		return img, rawRef, err
 	}
-	ref, err := refdocker.ParseDockerRef(rawRef)
+	if g.isDockerArchive(rawRef) {
+		img, err := g.getDockerArchive(ctx, rawRef, plats)
+		return img, rawRef, err
+	}
+	if g.isContainersStorage(rawRef) {
+		img, err := g.getContainersStorage(ctx, rawRef, plats)
+		return img, rawRef, err
+	}
+
+	resolvedRef, err := g.resolveShortName(ctx, rawRef)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse %q: %w", rawRef, err)
+		return nil, "", err
+	}
+	ref, err := refdocker.ParseDockerRef(resolvedRef)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse %q: %w", resolvedRef, err)
 	}
 	name := ref.String()
 
 	switch pullMode {
 	case PullAlways:
 		log.G(ctx).Infof("Pulling %q", name)
-		if err := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, name, plats); err != nil {
-			return nil, fmt.Errorf("failed to pull %q: %w", name, err)
+		if err := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, g.policyCtx, g.mirrors, name, plats); err != nil {
+			return nil, name, fmt.Errorf("failed to pull %q: %w", name, err)
 		}
 	case PullMissing, PullNever:
 		// NOP
 	default:
-		return nil, fmt.Errorf("unknown pull mode %q", pullMode)
+		return nil, name, fmt.Errorf("unknown pull mode %q", pullMode)
 	}
 
 	// Get the image object
@@ -216,36 +941,236 @@ func (g *ImageGetter) Get(ctx context.Context, rawRef string, plats []ocispec.Pl
 	if err != nil {
 		if errors.Is(err, errdefs.ErrNotFound) && pullMode != PullNever {
 			log.G(ctx).Infof("Pulling %q", name)
-			if pullErr := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, name, plats); pullErr != nil {
-				return nil, fmt.Errorf("failed to pull %q: %w", name, pullErr)
+			if pullErr := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, g.policyCtx, g.mirrors, name, plats); pullErr != nil {
+				return nil, name, fmt.Errorf("failed to pull %q: %w", name, pullErr)
 			}
 			var retryErr error
 			img, retryErr = g.imageStore.Get(ctx, name)
 			if retryErr != nil {
-				return nil, fmt.Errorf("should have pulled %q, but still not accessible in the local store: %w", name, retryErr)
+				return nil, name, fmt.Errorf("should have pulled %q, but still not accessible in the local store: %w", name, retryErr)
 			}
 			err = nil
 		}
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image %q: %w", name, err)
+		return nil, name, fmt.Errorf("failed to get image %q: %w", name, err)
 	}
 
 	// Check platforms
 	platMC := platforms.Any(plats...)
 	available, _, _, _, err := images.Check(ctx, g.contentStore, img.Target, platMC)
 	if err != nil {
-		return nil, err
+		return nil, name, err
 	}
 	if !available {
 		if pullMode == PullNever {
-			return nil, fmt.Errorf("image %q lacks blobs for additional platforms: %w", name, errdefs.ErrUnavailable)
+			return nil, name, fmt.Errorf("image %q lacks blobs for additional platforms: %w", name, errdefs.ErrUnavailable)
 		} else {
 			log.G(ctx).Infof("Pulling %q for additional platforms", name)
-			if err := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, name, plats); err != nil {
-				return nil, fmt.Errorf("failed to pull %q: %w", name, err)
+			if err := Pull(ctx, g.progressWriter, g.transferrer, g.credHelper, g.policyCtx, g.mirrors, name, plats); err != nil {
+				return nil, name, fmt.Errorf("failed to pull %q: %w", name, err)
 			}
 		}
 	}
-	return &img, nil
+	return &img, name, nil
+}
+
+// memoryImageStore is a minimal, process-local images.Store backed by a
+// map, used by GetEphemeral so a one-shot Get doesn't need a real metadata
+// database.
+type memoryImageStore struct {
+	mu     sync.Mutex
+	images map[string]images.Image
+}
+
+func newMemoryImageStore() *memoryImageStore {
+	return &memoryImageStore{images: make(map[string]images.Image)}
+}
+
+func (s *memoryImageStore) Get(ctx context.Context, name string) (images.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img, ok := s.images[name]
+	if !ok {
+		return images.Image{}, fmt.Errorf("image %q: %w", name, errdefs.ErrNotFound)
+	}
+	return img, nil
+}
+
+func (s *memoryImageStore) List(ctx context.Context, filters ...string) ([]images.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]images.Image, 0, len(s.images))
+	for _, img := range s.images {
+		out = append(out, img)
+	}
+	return out, nil
+}
+
+func (s *memoryImageStore) Create(ctx context.Context, image images.Image) (images.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.images[image.Name]; ok {
+		return images.Image{}, fmt.Errorf("image %q: %w", image.Name, errdefs.ErrAlreadyExists)
+	}
+	s.images[image.Name] = image
+	return image, nil
+}
+
+func (s *memoryImageStore) Update(ctx context.Context, image images.Image, fieldpaths ...string) (images.Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.images[image.Name]; !ok {
+		return images.Image{}, fmt.Errorf("image %q: %w", image.Name, errdefs.ErrNotFound)
+	}
+	s.images[image.Name] = image
+	return image, nil
+}
+
+func (s *memoryImageStore) Delete(ctx context.Context, name string, opts ...images.DeleteOpt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.images, name)
+	return nil
+}
+
+// memoryLeaseManager is a minimal, process-local leases.Manager backed by a
+// map, used by GetEphemeral's scratch transfer service. Transfer leases the
+// content it ingests to protect it for the duration of the import; since
+// the scratch content store is private to a single GetEphemeral call and
+// removed on Close, bookkeeping the leases in memory (rather than in a real
+// boltdb-backed manager) is enough to satisfy that contract.
+type memoryLeaseManager struct {
+	mu        sync.Mutex
+	leases    map[string]leases.Lease
+	resources map[string][]leases.Resource
+	counter   int
+}
+
+func newMemoryLeaseManager() *memoryLeaseManager {
+	return &memoryLeaseManager{
+		leases:    make(map[string]leases.Lease),
+		resources: make(map[string][]leases.Resource),
+	}
+}
+
+func (m *memoryLeaseManager) Create(ctx context.Context, opts ...leases.Opt) (leases.Lease, error) {
+	l := leases.Lease{CreatedAt: time.Now()}
+	for _, opt := range opts {
+		if err := opt(&l); err != nil {
+			return leases.Lease{}, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l.ID == "" {
+		m.counter++
+		l.ID = fmt.Sprintf("diffoci-ephemeral-%d", m.counter)
+	}
+	if _, ok := m.leases[l.ID]; ok {
+		return leases.Lease{}, fmt.Errorf("lease %q: %w", l.ID, errdefs.ErrAlreadyExists)
+	}
+	m.leases[l.ID] = l
+	return l, nil
+}
+
+func (m *memoryLeaseManager) Delete(ctx context.Context, l leases.Lease, _ ...leases.DeleteOpt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.leases, l.ID)
+	delete(m.resources, l.ID)
+	return nil
+}
+
+func (m *memoryLeaseManager) List(ctx context.Context, _ ...string) ([]leases.Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]leases.Lease, 0, len(m.leases))
+	for _, l := range m.leases {
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func (m *memoryLeaseManager) AddResource(ctx context.Context, l leases.Lease, r leases.Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[l.ID]; !ok {
+		return fmt.Errorf("lease %q: %w", l.ID, errdefs.ErrNotFound)
+	}
+	m.resources[l.ID] = append(m.resources[l.ID], r)
+	return nil
+}
+
+func (m *memoryLeaseManager) DeleteResource(ctx context.Context, l leases.Lease, r leases.Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rs := m.resources[l.ID]
+	for i, existing := range rs {
+		if existing == r {
+			m.resources[l.ID] = append(rs[:i], rs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryLeaseManager) ListResources(ctx context.Context, l leases.Lease) ([]leases.Resource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]leases.Resource(nil), m.resources[l.ID]...), nil
+}
+
+// EphemeralImage is an image resolved via GetEphemeral: its blobs live in a
+// scratch content store rooted under os.TempDir(), not the backend's
+// shared store. Close must be called once the caller is done reading from
+// Provider, to remove the scratch directory.
+type EphemeralImage struct {
+	Image    images.Image
+	Provider content.Provider
+
+	dir string
+}
+
+// Close removes the scratch directory backing the EphemeralImage's blobs.
+func (e *EphemeralImage) Close() error {
+	return os.RemoveAll(e.dir)
+}
+
+// GetEphemeral resolves rawRef exactly as Get does, but transfers the image
+// into a per-call scratch content store and an in-memory image store
+// instead of the backend's shared ones. This avoids doubling disk usage
+// and boltdb contention for one-shot comparisons, at the cost of the image
+// not persisting past Close.
+func (g *ImageGetter) GetEphemeral(ctx context.Context, rawRef string, plats []ocispec.Platform) (*EphemeralImage, error) {
+	dir, err := os.MkdirTemp(os.TempDir(), "diffoci-ephemeral-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch dir: %w", err)
+	}
+	scratchContent, err := localcontent.NewStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create scratch content store: %w", err)
+	}
+	scratchImages := newMemoryImageStore()
+	scratchLeases := newMemoryLeaseManager()
+	scratchTransferrer := localtransfer.NewTransferService(scratchLeases, scratchContent, scratchImages)
+
+	scratch := &ImageGetter{
+		progressWriter: g.progressWriter,
+		imageStore:     scratchImages,
+		contentStore:   scratchContent,
+		transferrer:    scratchTransferrer,
+		credHelper:     g.credHelper,
+		shortNameMode:  g.shortNameMode,
+		policyCtx:      g.policyCtx,
+		mirrors:        g.mirrors,
+	}
+
+	img, _, err := scratch.Get(ctx, rawRef, plats, PullAlways)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &EphemeralImage{Image: *img, Provider: scratchContent, dir: dir}, nil
 }
@@ -0,0 +1,387 @@
+package imagegetter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSplitTransportRef(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantPath string
+		wantTag  string
+	}{
+		{"/path/to/image.tar", "/path/to/image.tar", ""},
+		{"/path/to/image.tar:latest", "/path/to/image.tar", "latest"},
+		{"/path/to/image.tar:v1.2.3", "/path/to/image.tar", "v1.2.3"},
+		{"relative/dir", "relative/dir", ""},
+		// A colon with a path separator somewhere after it isn't a tag.
+		{"/path/with:colon/image.tar", "/path/with:colon/image.tar", ""},
+	}
+	for _, tc := range cases {
+		path, tag := splitTransportRef(tc.in)
+		if path != tc.wantPath || tag != tc.wantTag {
+			t.Errorf("splitTransportRef(%q) = (%q, %q), want (%q, %q)", tc.in, path, tag, tc.wantPath, tc.wantTag)
+		}
+	}
+}
+
+func TestDockerHubFallbackRef(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"alpine", "docker.io/library/alpine"},
+		{"fedora/httpd", "docker.io/fedora/httpd"},
+		{"bitnami/nginx", "docker.io/bitnami/nginx"},
+	}
+	for _, tc := range cases {
+		if got := dockerHubFallbackRef(tc.in); got != tc.want {
+			t.Errorf("dockerHubFallbackRef(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestIsMirrorFallbackError(t *testing.T) {
+	if !isMirrorFallbackError(errdefs.ErrNotFound) {
+		t.Error("errdefs.ErrNotFound should be a fallback error")
+	}
+	if !isMirrorFallbackError(errors.New("http: 404 Not Found")) {
+		t.Error("404 message should be a fallback error")
+	}
+	if !isMirrorFallbackError(errors.New("unauthorized: access denied")) {
+		t.Error("unauthorized message should be a fallback error")
+	}
+	if isMirrorFallbackError(errors.New("connection reset by peer")) {
+		t.Error("an unrelated transport error should not be a fallback error")
+	}
+}
+
+func TestMirrorConfigCandidates(t *testing.T) {
+	t.Run("nil receiver", func(t *testing.T) {
+		var mc *MirrorConfig
+		got := mc.candidates("docker.io/library/alpine:latest")
+		want := []string{"docker.io/library/alpine:latest"}
+		if !slicesEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("bare host mirror", func(t *testing.T) {
+		mc := NewMirrorConfig().WithMirror("docker.io", "zot.example.com:5000")
+		got := mc.candidates("docker.io/library/alpine:latest")
+		want := []string{"zot.example.com:5000/library/alpine:latest", "docker.io/library/alpine:latest"}
+		if !slicesEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("path-scoped mirror only matches its prefix", func(t *testing.T) {
+		mc := NewMirrorConfig().WithMirror("docker.io/library", "zot.example.com:5000")
+		if got := mc.candidates("docker.io/fedora/httpd:latest"); !slicesEqual(got, []string{"docker.io/fedora/httpd:latest"}) {
+			t.Errorf("path-scoped mirror leaked into an unrelated repo: %v", got)
+		}
+		got := mc.candidates("docker.io/library/alpine:latest")
+		want := []string{"zot.example.com:5000/alpine:latest", "docker.io/library/alpine:latest"}
+		if !slicesEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("wildcard domain mirror", func(t *testing.T) {
+		mc := NewMirrorConfig().WithMirror("*.quay.io", "zot.example.com:5000")
+		got := mc.candidates("sub.quay.io/foo/bar:latest")
+		want := []string{"zot.example.com:5000/foo/bar:latest", "sub.quay.io/foo/bar:latest"}
+		if !slicesEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		// A "*.example.com" wildcard must not also match the apex domain.
+		if got := mc.candidates("quay.io/foo/bar:latest"); !slicesEqual(got, []string{"quay.io/foo/bar:latest"}) {
+			t.Errorf("wildcard mirror leaked into the apex domain: %v", got)
+		}
+	})
+
+	t.Run("more specific prefix wins", func(t *testing.T) {
+		mc := NewMirrorConfig().
+			WithMirror("docker.io", "generic.example.com:5000").
+			WithMirror("docker.io/library", "library.example.com:5000")
+		got := mc.candidates("docker.io/library/alpine:latest")
+		want := []string{"library.example.com:5000/alpine:latest", "docker.io/library/alpine:latest"}
+		if !slicesEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("tag-only and digest-only filtering", func(t *testing.T) {
+		const digest = "docker.io/library/alpine@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		mc := NewMirrorConfig().
+			WithMirror("docker.io", "tagonly.example.com:5000", WithPullFromMirror(PullFromMirrorTagOnly)).
+			WithMirror("docker.io", "digestonly.example.com:5000", WithPullFromMirror(PullFromMirrorDigestOnly))
+
+		gotTag := mc.candidates("docker.io/library/alpine:latest")
+		wantTag := []string{"tagonly.example.com:5000/library/alpine:latest", "docker.io/library/alpine:latest"}
+		if !slicesEqual(gotTag, wantTag) {
+			t.Errorf("tag ref: got %v, want %v", gotTag, wantTag)
+		}
+
+		gotDigest := mc.candidates(digest)
+		if len(gotDigest) != 2 || gotDigest[0] != "digestonly.example.com:5000/library/alpine@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" {
+			t.Errorf("digest ref: got %v", gotDigest)
+		}
+	})
+}
+
+func TestTarDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "blobs.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "nested.txt"), []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tarDirectory(dir, &buf); err != nil {
+		t.Fatalf("tarDirectory failed: %v", err)
+	}
+
+	names := map[string]bool{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		names[hdr.Name] = true
+	}
+	for _, want := range []string{"blobs.txt", "sub/nested.txt"} {
+		if !names[want] {
+			t.Errorf("tar is missing entry %q, got %v", want, names)
+		}
+	}
+}
+
+func TestMemoryImageStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryImageStore()
+
+	if _, err := s.Get(ctx, "missing"); !errdefs.IsNotFound(err) {
+		t.Fatalf("Get on empty store: want ErrNotFound, got %v", err)
+	}
+
+	img := images.Image{Name: "docker.io/library/alpine:latest"}
+	created, err := s.Create(ctx, img)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.Name != img.Name {
+		t.Fatalf("Create returned %+v, want name %q", created, img.Name)
+	}
+
+	if _, err := s.Create(ctx, img); !errdefs.IsAlreadyExists(err) {
+		t.Fatalf("Create duplicate: want ErrAlreadyExists, got %v", err)
+	}
+
+	got, err := s.Get(ctx, img.Name)
+	if err != nil || got.Name != img.Name {
+		t.Fatalf("Get: got %+v, %v", got, err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List: got %v, %v", list, err)
+	}
+
+	if err := s.Delete(ctx, img.Name); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, img.Name); !errdefs.IsNotFound(err) {
+		t.Fatalf("Get after Delete: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryLeaseManager(t *testing.T) {
+	ctx := context.Background()
+	m := newMemoryLeaseManager()
+
+	l, err := m.Create(ctx)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if l.ID == "" {
+		t.Fatal("Create did not assign an ID")
+	}
+
+	r := leases.Resource{ID: "sha256:aaaa", Type: "content"}
+	if err := m.AddResource(ctx, l, r); err != nil {
+		t.Fatalf("AddResource: %v", err)
+	}
+
+	rs, err := m.ListResources(ctx, l)
+	if err != nil || len(rs) != 1 || rs[0] != r {
+		t.Fatalf("ListResources: got %v, %v", rs, err)
+	}
+
+	if err := m.DeleteResource(ctx, l, r); err != nil {
+		t.Fatalf("DeleteResource: %v", err)
+	}
+	rs, err = m.ListResources(ctx, l)
+	if err != nil || len(rs) != 0 {
+		t.Fatalf("ListResources after delete: got %v, %v", rs, err)
+	}
+
+	if err := m.Delete(ctx, l); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	list, err := m.List(ctx)
+	if err != nil || len(list) != 0 {
+		t.Fatalf("List after delete: got %v, %v", list, err)
+	}
+
+	if err := m.AddResource(ctx, l, r); !errdefs.IsNotFound(err) {
+		t.Fatalf("AddResource on deleted lease: want ErrNotFound, got %v", err)
+	}
+}
+
+// writeOCIArchive builds a minimal single-manifest OCI image archive (the
+// format skopeo writes for oci-archive:, and the one getOCIArchive reads via
+// the existing Load path) at path, for plat, and returns the descriptors of
+// its config and manifest blobs along with the config blob's bytes.
+func writeOCIArchive(t *testing.T, path string, plat ocispec.Platform) (configDesc, manifestDesc ocispec.Descriptor, configBytes []byte) {
+	t.Helper()
+
+	config := ocispec.Image{
+		Platform: ocispec.Platform{Architecture: plat.Architecture, OS: plat.OS},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{}},
+	}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	configDesc = ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{},
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+	manifestDesc = ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifestBytes),
+		Size:      int64(len(manifestBytes)),
+		Platform:  &plat,
+	}
+
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifestDesc},
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("marshal index: %v", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %q: %v", path, err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+
+	addFile := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0o644}); err != nil {
+			t.Fatalf("tar header %q: %v", name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatalf("tar write %q: %v", name, err)
+		}
+	}
+	addFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	addFile("index.json", indexBytes)
+	addFile("blobs/sha256/"+manifestDesc.Digest.Encoded(), manifestBytes)
+	addFile("blobs/sha256/"+configDesc.Digest.Encoded(), configBytes)
+
+	return configDesc, manifestDesc, configBytes
+}
+
+func TestGetEphemeralFromOCIArchive(t *testing.T) {
+	ctx := context.Background()
+	plat := platforms.DefaultSpec()
+
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "image.tar")
+	configDesc, manifestDesc, configBytes := writeOCIArchive(t, archivePath, plat)
+
+	g := &ImageGetter{progressWriter: io.Discard}
+	eph, err := g.GetEphemeral(ctx, ociArchiveImagePrefix+archivePath, []ocispec.Platform{plat})
+	if err != nil {
+		t.Fatalf("GetEphemeral: %v", err)
+	}
+	defer func() {
+		if err := eph.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}()
+
+	if eph.Image.Target.Digest != manifestDesc.Digest {
+		t.Fatalf("resolved manifest digest = %v, want %v", eph.Image.Target.Digest, manifestDesc.Digest)
+	}
+
+	ra, err := eph.Provider.ReaderAt(ctx, configDesc)
+	if err != nil {
+		t.Fatalf("ReaderAt(config): %v", err)
+	}
+	defer ra.Close()
+	got := make([]byte, ra.Size())
+	if _, err := ra.ReadAt(got, 0); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt(config): %v", err)
+	}
+	if !bytes.Equal(got, configBytes) {
+		t.Errorf("config blob read back = %q, want %q", got, configBytes)
+	}
+}
+
+func slicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}